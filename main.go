@@ -0,0 +1,830 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultNWorkers  = 4
+	defaultChunkSize = 1 << 20 // 1MiB
+
+	// mirrorQuarantine is how long a source is skipped after it errors or
+	// times out while serving a chunk.
+	mirrorQuarantine = 30 * time.Second
+
+	defaultInitialBackoff = 100 * time.Millisecond
+	defaultMaxBackoff     = 5 * time.Second
+)
+
+// ChunkClient downloads a file over HTTP by splitting it into byte-range
+// chunks and fetching them concurrently.
+type ChunkClient struct {
+	Client http.Client
+
+	// NWorkers is the number of chunks to fetch concurrently. If zero,
+	// defaultNWorkers is used.
+	NWorkers int
+
+	// ChunkSize is the size, in bytes, of each Range request. If zero,
+	// defaultChunkSize is used.
+	ChunkSize int64
+
+	// VerifyETag causes each chunk's ETag to be compared against the
+	// ETag observed on the initial HEAD request, failing the download if
+	// the remote file changed mid-transfer.
+	VerifyETag bool
+
+	// Hash and Checksum, if both set, are used to verify the whole
+	// downloaded file once GetFile completes: out is reread in full,
+	// hashed with Hash, and the result compared against Checksum. This
+	// catches corruption that VerifyETag can't, since it only guards
+	// mid-download consistency on servers that happen to send ETags.
+	Hash     hash.Hash
+	Checksum []byte
+
+	// Resumable causes GetFile to track completed chunks in an on-disk
+	// manifest (the output path plus resumeManifestSuffix), so a
+	// subsequent GetFile call for the same URL and output can pick up
+	// where a previous, interrupted call left off instead of
+	// redownloading the whole file. It requires out to implement namer,
+	// which *os.File satisfies.
+	Resumable bool
+
+	// Sources, when set, lists equivalent URLs that GetFile treats as
+	// interchangeable mirrors: chunk Range requests are distributed
+	// across them round-robin, and a source that errors or times out is
+	// quarantined for mirrorQuarantine while its pending chunk is
+	// retried on another source. If unset, GetFile behaves as though
+	// Sources were []string{url}.
+	Sources []string
+
+	// MaxRetries is how many additional attempts a chunk request gets on
+	// the same source after a network error, a 5xx, or a 408/429
+	// response, before the error is surfaced (or, if Sources has other
+	// members, the source is quarantined and another is tried). Other
+	// 4xx responses are never retried. If zero, a chunk request is
+	// attempted only once.
+	MaxRetries int
+
+	// InitialBackoff and MaxBackoff bound the exponential backoff
+	// between retries: the wait before retry N is
+	// min(MaxBackoff, InitialBackoff*2^N) plus jitter, or the response's
+	// Retry-After if present. Zero values fall back to
+	// defaultInitialBackoff and defaultMaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// Progress, if set, is invoked after every chunk's WriteAt with the
+	// total bytes written so far and the total file size. It's called
+	// concurrently from every worker and must be safe for that.
+	Progress func(completedBytes, totalBytes int64)
+
+	// MaxBytesPerSecond, if positive, caps the combined transfer rate of
+	// all workers via a shared token-bucket limiter wrapped around each
+	// chunk's response body.
+	MaxBytesPerSecond int64
+}
+
+// ErrChecksumMismatch is returned by GetFile when Hash and Checksum are set
+// and the downloaded file's digest doesn't match Checksum.
+var ErrChecksumMismatch = errors.New("chunked-downloader: checksum mismatch")
+
+// ErrMirrorMismatch is returned by GetFile when Sources are set and the
+// mirrors don't agree on the file being downloaded (different sizes, or
+// different ETags when VerifyETag is set).
+var ErrMirrorMismatch = errors.New("chunked-downloader: mirrors disagree on file")
+
+// truncater is implemented by writers that can pre-allocate their backing
+// storage, such as *os.File. GetFile type-asserts for it to size the output
+// up front instead of relying on WriteAt to grow it chunk by chunk.
+type truncater interface {
+	Truncate(size int64) error
+}
+
+// namer is implemented by writers with a stable on-disk path, such as
+// *os.File. Resumable downloads type-assert for it to locate the chunk
+// manifest alongside the output file.
+type namer interface {
+	Name() string
+}
+
+// resumeManifestSuffix is appended to the output path to derive the
+// manifest path for a resumable download, e.g. "out.bin.cdpart".
+const resumeManifestSuffix = ".cdpart"
+
+// resumeManifest records, for a single URL+output pair, which chunks have
+// already been written to disk so a subsequent download can skip them. It is
+// fsync'd to path after every chunk so a crash never loses completed work.
+type resumeManifest struct {
+	path string
+	mu   sync.Mutex
+
+	Size      int64
+	ETag      string
+	ChunkSize int64
+	Done      map[int64]bool
+}
+
+// loadResumeManifest reads the manifest at path if one exists and still
+// matches size, etag and chunkSize, or otherwise starts a fresh one.
+func loadResumeManifest(path string, size int64, etag string, chunkSize int64) (*resumeManifest, error) {
+	m := &resumeManifest{path: path}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if jsonErr := json.Unmarshal(data, m); jsonErr == nil &&
+			m.Size == size && m.ETag == etag && m.ChunkSize == chunkSize {
+			return m, nil
+		}
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+
+	m.Size, m.ETag, m.ChunkSize = size, etag, chunkSize
+	m.Done = map[int64]bool{}
+	return m, nil
+}
+
+func (m *resumeManifest) isDone(offset int64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.Done[offset]
+}
+
+// doneBytes sums the byte length of every chunk already recorded as done,
+// so a resumed download's Progress callbacks can report total bytes
+// written rather than just bytes fetched by the current call.
+func (m *resumeManifest) doneBytes(size, chunkSize int64) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var total int64
+	for offset := range m.Done {
+		end := offset + chunkSize
+		if end > size {
+			end = size
+		}
+		total += end - offset
+	}
+	return total
+}
+
+// markDone records offset as complete and fsyncs the manifest to disk.
+func (m *resumeManifest) markDone(offset int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Done[offset] = true
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(m.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+func (m *resumeManifest) remove() error {
+	err := os.Remove(m.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// reset discards all recorded progress and removes the on-disk manifest, so
+// a subsequent download starts over from scratch rather than treating
+// corrupt data as already done.
+func (m *resumeManifest) reset() error {
+	m.mu.Lock()
+	m.Done = map[int64]bool{}
+	m.mu.Unlock()
+	return m.remove()
+}
+
+// GetFile downloads url into out, splitting the transfer into ChunkSize
+// chunks fetched by NWorkers workers in parallel. out may be any
+// io.WriterAt: a file, an in-memory buffer, or a custom sink such as an S3
+// multipart uploader.
+func (c *ChunkClient) GetFile(url string, out io.WriterAt) error {
+	sources := c.Sources
+	if len(sources) == 0 {
+		sources = []string{url}
+	}
+
+	size, etag, err := c.headSources(sources)
+	if err != nil {
+		return err
+	}
+
+	if t, ok := out.(truncater); ok {
+		if err := t.Truncate(size); err != nil {
+			return err
+		}
+	}
+
+	var manifest *resumeManifest
+	if c.Resumable {
+		f, ok := out.(namer)
+		if !ok {
+			return errors.New("chunked-downloader: Resumable requires out to be backed by a named file")
+		}
+		manifest, err = loadResumeManifest(f.Name()+resumeManifestSuffix, size, etag, c.chunkSize())
+		if err != nil {
+			return err
+		}
+	}
+
+	nWorkers := c.NWorkers
+	if nWorkers == 0 {
+		nWorkers = defaultNWorkers
+	}
+
+	pool := newSourcePool(sources)
+	var limiter *tokenBucket
+	if c.MaxBytesPerSecond > 0 {
+		limiter = newTokenBucket(c.MaxBytesPerSecond)
+	}
+	var completed int64
+	if manifest != nil {
+		completed = manifest.doneBytes(size, c.chunkSize())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	offsets := make(chan int64)
+	errs := make(chan error, 1)
+	var reportErr sync.Once
+
+	var wg sync.WaitGroup
+	for i := 0; i < nWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for offset := range offsets {
+				n, err := c.getChunkInto(pool, limiter, offset, etag, out)
+				if err != nil {
+					reportErr.Do(func() {
+						errs <- err
+						cancel()
+					})
+					return
+				}
+				if manifest != nil {
+					if err := manifest.markDone(offset); err != nil {
+						reportErr.Do(func() {
+							errs <- fmt.Errorf("chunk at offset %d: %w", offset, err)
+							cancel()
+						})
+						return
+					}
+				}
+				if c.Progress != nil {
+					c.Progress(atomic.AddInt64(&completed, int64(n)), size)
+				}
+			}
+		}()
+	}
+
+dispatch:
+	for offset := int64(0); offset < size; offset += c.chunkSize() {
+		if manifest != nil && manifest.isDone(offset) {
+			continue
+		}
+		select {
+		case offsets <- offset:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(offsets)
+	wg.Wait()
+
+	select {
+	case err := <-errs:
+		return err
+	default:
+	}
+
+	if c.Hash != nil && len(c.Checksum) > 0 {
+		if err := c.verifyChecksum(out, size); err != nil {
+			if manifest != nil {
+				if resetErr := manifest.reset(); resetErr != nil {
+					return resetErr
+				}
+			}
+			return err
+		}
+	}
+
+	if manifest != nil {
+		if err := manifest.remove(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyChecksum rereads the first size bytes of out through c.Hash and
+// compares the result against c.Checksum.
+func (c *ChunkClient) verifyChecksum(out io.WriterAt, size int64) error {
+	r, ok := out.(io.ReaderAt)
+	if !ok {
+		return fmt.Errorf("chunked-downloader: checksum verification requires out to implement io.ReaderAt")
+	}
+	c.Hash.Reset()
+	if _, err := io.Copy(c.Hash, io.NewSectionReader(r, 0, size)); err != nil {
+		return err
+	}
+	if !bytes.Equal(c.Hash.Sum(nil), c.Checksum) {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+// GetFileWithSidecarChecksum downloads url into out the same as GetFile, but
+// first fetches a sidecar checksum file (url+".sha256", falling back to
+// url+".md5") and wires its digest into c.Hash/c.Checksum to verify the
+// download.
+func (c *ChunkClient) GetFileWithSidecarChecksum(url string, out io.WriterAt) error {
+	sidecars := []struct {
+		ext     string
+		newHash func() hash.Hash
+	}{
+		{"sha256", sha256.New},
+		{"md5", md5.New},
+	}
+
+	var err error
+	for _, sidecar := range sidecars {
+		var checksum []byte
+		checksum, err = c.fetchSidecarChecksum(url + "." + sidecar.ext)
+		if err != nil {
+			continue
+		}
+		withChecksum := *c
+		withChecksum.Hash = sidecar.newHash()
+		withChecksum.Checksum = checksum
+		return withChecksum.GetFile(url, out)
+	}
+	return fmt.Errorf("chunked-downloader: no .sha256 or .md5 sidecar found for %s: %w", url, err)
+}
+
+// fetchSidecarChecksum fetches and parses a checksum sidecar file in the
+// common sha256sum/md5sum format: a hex digest optionally followed by
+// whitespace and a filename.
+func (c *ChunkClient) fetchSidecarChecksum(url string) ([]byte, error) {
+	res, err := c.Client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: unexpected status %s", url, res.Status)
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(string(body))
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("%s: empty sidecar checksum file", url)
+	}
+	return hex.DecodeString(fields[0])
+}
+
+// getChunkInto fetches a single chunk starting at offset from one of pool's
+// sources and writes it into out at the same offset, returning the number
+// of bytes written. If a source errors, it is quarantined and the chunk is
+// retried on another source.
+func (c *ChunkClient) getChunkInto(pool *sourcePool, limiter *tokenBucket, offset int64, etag string, out io.WriterAt) (int, error) {
+	body, err := c.fetchChunk(pool, limiter, offset, etag)
+	if err != nil {
+		return 0, fmt.Errorf("chunk at offset %d: %w", offset, err)
+	}
+	if _, err := out.WriteAt(body, offset); err != nil {
+		return 0, fmt.Errorf("chunk at offset %d: %w", offset, err)
+	}
+	return len(body), nil
+}
+
+// fetchChunk fetches a single chunk from one of pool's sources, trying
+// another source whenever one errors or times out.
+func (c *ChunkClient) fetchChunk(pool *sourcePool, limiter *tokenBucket, offset int64, etag string) ([]byte, error) {
+	tried := map[string]bool{}
+	var lastErr error
+	for {
+		source, ok := pool.pick(tried)
+		if !ok {
+			if lastErr == nil {
+				lastErr = errors.New("no available source")
+			}
+			return nil, lastErr
+		}
+		tried[source] = true
+
+		body, err := c.fetchChunkFrom(source, limiter, offset, etag)
+		if err != nil {
+			pool.quarantine(source)
+			lastErr = err
+			continue
+		}
+		return body, nil
+	}
+}
+
+// fetchChunkFrom issues a single Range request against source, retrying on
+// the same source per c.MaxRetries, and returns the chunk body. If limiter
+// is set, the response body is read through it to cap the transfer rate.
+func (c *ChunkClient) fetchChunkFrom(source string, limiter *tokenBucket, offset int64, etag string) ([]byte, error) {
+	res, err := c.getChunkWithRetry(source, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if c.VerifyETag {
+		if chunkETag := res.Header.Get("ETag"); chunkETag != "" && chunkETag != etag {
+			return nil, errors.New("ETag changed mid-download")
+		}
+	}
+
+	var body io.Reader = res.Body
+	if limiter != nil {
+		body = &throttledReader{r: res.Body, bucket: limiter}
+	}
+	return io.ReadAll(body)
+}
+
+// httpStatusError is returned by getChunk when the response status wasn't
+// 206 Partial Content, so retry logic can inspect the status code and any
+// Retry-After header without reparsing the error string.
+type httpStatusError struct {
+	status     string
+	statusCode int
+	retryAfter time.Duration
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("unexpected status %s", e.status)
+}
+
+// getChunkWithRetry wraps getChunk in a retry loop: network errors, 5xx, and
+// 408/429 responses are retried up to c.MaxRetries times with exponential
+// backoff and jitter (or the response's Retry-After, if present); any other
+// error is returned immediately.
+func (c *ChunkClient) getChunkWithRetry(source string, offset int64) (*http.Response, error) {
+	var lastErr error
+	for attempt := 0; attempt <= c.MaxRetries; attempt++ {
+		res, err := c.getChunk(source, offset)
+		if err == nil {
+			return res, nil
+		}
+		lastErr = err
+		if attempt == c.MaxRetries || !isRetryable(err) {
+			return nil, err
+		}
+
+		wait := backoffDuration(attempt, c.initialBackoff(), c.maxBackoff())
+		var statusErr *httpStatusError
+		if errors.As(err, &statusErr) && statusErr.retryAfter > 0 {
+			wait = statusErr.retryAfter
+		}
+		time.Sleep(wait)
+	}
+	return nil, lastErr
+}
+
+// isRetryable reports whether err is a transient failure worth retrying:
+// any non-HTTP error (network failures, timeouts), a 5xx, or 408/429.
+func isRetryable(err error) bool {
+	var statusErr *httpStatusError
+	if !errors.As(err, &statusErr) {
+		return true
+	}
+	switch statusErr.statusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	default:
+		return statusErr.statusCode >= 500
+	}
+}
+
+// backoffDuration returns the wait before retry attempt, clamped to max and
+// padded with up to backoff/2 of jitter.
+func backoffDuration(attempt int, initial, max time.Duration) time.Duration {
+	shift := attempt
+	if shift > 32 {
+		shift = 32
+	}
+	backoff := initial * time.Duration(1<<uint(shift))
+	if backoff <= 0 || backoff > max {
+		backoff = max
+	}
+	jitter := time.Duration(rand.Int63n(int64(backoff/2) + 1))
+	return backoff + jitter
+}
+
+func (c *ChunkClient) initialBackoff() time.Duration {
+	if c.InitialBackoff > 0 {
+		return c.InitialBackoff
+	}
+	return defaultInitialBackoff
+}
+
+func (c *ChunkClient) maxBackoff() time.Duration {
+	if c.MaxBackoff > 0 {
+		return c.MaxBackoff
+	}
+	return defaultMaxBackoff
+}
+
+// tokenBucket is a global rate limiter shared across all of a download's
+// workers: every chunk's response body is read through it, so the combined
+// transfer rate stays under ratePerSecond regardless of how many chunks are
+// in flight at once.
+type tokenBucket struct {
+	mu         sync.Mutex
+	tokens     float64
+	rate       float64
+	capacity   float64
+	lastRefill time.Time
+}
+
+func newTokenBucket(ratePerSecond int64) *tokenBucket {
+	rate := float64(ratePerSecond)
+	return &tokenBucket{tokens: rate, rate: rate, capacity: rate, lastRefill: time.Now()}
+}
+
+// take blocks until n bytes' worth of tokens are available, refilling at
+// b.rate bytes/second for the elapsed time since the last call.
+func (b *tokenBucket) take(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	need := float64(n)
+	for {
+		now := time.Now()
+		b.tokens += now.Sub(b.lastRefill).Seconds() * b.rate
+		if b.tokens > b.capacity {
+			b.tokens = b.capacity
+		}
+		b.lastRefill = now
+
+		if b.tokens >= need {
+			b.tokens -= need
+			return
+		}
+
+		wait := time.Duration((need - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+		time.Sleep(wait)
+		b.mu.Lock()
+	}
+}
+
+// throttledReader reads from r, consuming tokens from bucket for every byte
+// read so the overall transfer rate stays within bucket's rate.
+type throttledReader struct {
+	r      io.Reader
+	bucket *tokenBucket
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n > 0 {
+		t.bucket.take(n)
+	}
+	return n, err
+}
+
+// parseRetryAfter parses a Retry-After header, which may be either a
+// delay in seconds or an HTTP-date.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// getChunk issues a single Range request for the chunk starting at offset.
+// The caller is responsible for closing the response body.
+func (c *ChunkClient) getChunk(url string, offset int64) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+c.chunkSize()-1))
+
+	res, err := c.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusPartialContent {
+		retryAfter := parseRetryAfter(res.Header.Get("Retry-After"))
+		res.Body.Close()
+		return nil, &httpStatusError{status: res.Status, statusCode: res.StatusCode, retryAfter: retryAfter}
+	}
+	return res, nil
+}
+
+// head issues a HEAD request to determine the total size and ETag of url.
+func (c *ChunkClient) head(url string) (size int64, etag string, err error) {
+	res, err := c.Client.Head(url)
+	if err != nil {
+		return 0, "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return 0, "", fmt.Errorf("HEAD %s: unexpected status %s", url, res.Status)
+	}
+	return res.ContentLength, res.Header.Get("ETag"), nil
+}
+
+// headSources HEADs every source in parallel and confirms they agree on the
+// file: a mismatched size always fails, and a mismatched ETag fails only
+// when VerifyETag is set (some mirrors may simply not send one).
+func (c *ChunkClient) headSources(sources []string) (size int64, etag string, err error) {
+	type result struct {
+		size int64
+		etag string
+		err  error
+	}
+	results := make([]result, len(sources))
+
+	var wg sync.WaitGroup
+	for i, source := range sources {
+		wg.Add(1)
+		go func(i int, source string) {
+			defer wg.Done()
+			size, etag, err := c.head(source)
+			results[i] = result{size, etag, err}
+		}(i, source)
+	}
+	wg.Wait()
+
+	if results[0].err != nil {
+		return 0, "", results[0].err
+	}
+	size, etag = results[0].size, results[0].etag
+
+	for i := 1; i < len(results); i++ {
+		r := results[i]
+		if r.err != nil {
+			return 0, "", r.err
+		}
+		if r.size != size {
+			return 0, "", fmt.Errorf("%w: %s reports size %d, %s reports size %d",
+				ErrMirrorMismatch, sources[0], size, sources[i], r.size)
+		}
+		if c.VerifyETag && r.etag != etag {
+			return 0, "", fmt.Errorf("%w: %s reports ETag %q, %s reports ETag %q",
+				ErrMirrorMismatch, sources[0], etag, sources[i], r.etag)
+		}
+	}
+	return size, etag, nil
+}
+
+// sourcePool round-robins chunk requests across a set of equivalent
+// sources, temporarily skipping ones that have recently errored.
+type sourcePool struct {
+	sources []string
+
+	mu          sync.Mutex
+	next        int
+	quarantined map[string]time.Time
+}
+
+func newSourcePool(sources []string) *sourcePool {
+	return &sourcePool{sources: sources, quarantined: map[string]time.Time{}}
+}
+
+// pick returns the next non-excluded, non-quarantined source in round-robin
+// order, or false if none are available.
+func (p *sourcePool) pick(exclude map[string]bool) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for i := 0; i < len(p.sources); i++ {
+		idx := (p.next + i) % len(p.sources)
+		source := p.sources[idx]
+		if exclude[source] {
+			continue
+		}
+		if until, ok := p.quarantined[source]; ok && now.Before(until) {
+			continue
+		}
+		p.next = idx + 1
+		return source, true
+	}
+	return "", false
+}
+
+// quarantine skips source for new chunks for mirrorQuarantine.
+func (p *sourcePool) quarantine(source string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.quarantined[source] = time.Now().Add(mirrorQuarantine)
+}
+
+func (c *ChunkClient) chunkSize() int64 {
+	if c.ChunkSize > 0 {
+		return c.ChunkSize
+	}
+	return defaultChunkSize
+}
+
+func main() {
+	nWorkers := flag.Int("workers", defaultNWorkers, "number of chunks to download concurrently")
+	chunkSize := flag.Int64("chunk-size", defaultChunkSize, "size in bytes of each downloaded chunk")
+	verifyETag := flag.Bool("verify-etag", false, "fail the download if the file's ETag changes mid-transfer")
+	sidecarChecksum := flag.Bool("sidecar-checksum", false, "verify the download against a .sha256/.md5 sidecar file")
+	resumable := flag.Bool("resume", false, "resume an interrupted download using an on-disk chunk manifest")
+	mirrors := flag.String("mirrors", "", "comma-separated list of additional mirror URLs equivalent to <url>")
+	maxRetries := flag.Int("max-retries", 0, "number of times to retry a chunk on transient errors")
+	initialBackoff := flag.Duration("initial-backoff", defaultInitialBackoff, "initial backoff between chunk retries")
+	maxBackoffFlag := flag.Duration("max-backoff", defaultMaxBackoff, "maximum backoff between chunk retries")
+	maxBytesPerSecond := flag.Int64("max-bytes-per-sec", 0, "limit download bandwidth to this many bytes per second (0 for unlimited)")
+	progress := flag.Bool("progress", false, "print download progress to stderr")
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] <url> <out-file>\n", os.Args[0])
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	url, outPath := flag.Arg(0), flag.Arg(1)
+
+	// Resuming requires opening the existing file without truncating it.
+	out, err := os.OpenFile(outPath, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer out.Close()
+
+	c := ChunkClient{
+		NWorkers:          *nWorkers,
+		ChunkSize:         *chunkSize,
+		VerifyETag:        *verifyETag,
+		Resumable:         *resumable,
+		MaxRetries:        *maxRetries,
+		InitialBackoff:    *initialBackoff,
+		MaxBackoff:        *maxBackoffFlag,
+		MaxBytesPerSecond: *maxBytesPerSecond,
+	}
+	if *mirrors != "" {
+		c.Sources = append([]string{url}, strings.Split(*mirrors, ",")...)
+	}
+	if *progress {
+		c.Progress = func(completed, total int64) {
+			fmt.Fprintf(os.Stderr, "\r%d/%d bytes", completed, total)
+			if completed == total {
+				fmt.Fprintln(os.Stderr)
+			}
+		}
+	}
+	if *sidecarChecksum {
+		err = c.GetFileWithSidecarChecksum(url, out)
+	} else {
+		err = c.GetFile(url, out)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
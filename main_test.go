@@ -1,16 +1,24 @@
 package main
 
 import (
+	"bytes"
 	"crypto/md5"
+	"crypto/sha256"
 	"crypto/tls"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 var ts *httptest.Server
@@ -19,11 +27,52 @@ var ts *httptest.Server
 const testIn = "/frankenstein.txt"
 const testChunkSize = 256
 
-// Normally I would make ChunkClient accept an io.ReadWriter interface (instead
-// of the concreate type *os.File) and use a buffer for testing.
-// bytes.Buffer doesn't implement WriteAt, so I'm writing to a file in a tmp
-// directory instead.
-const testOut = "tmp/out.txt"
+// memWriterAt is a minimal in-memory io.WriterAt, since bytes.Buffer doesn't
+// implement WriteAt and chunks can land out of order.
+type memWriterAt struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+func (w *memWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	end := off + int64(len(p))
+	if end > int64(len(w.data)) {
+		grown := make([]byte, end)
+		copy(grown, w.data)
+		w.data = grown
+	}
+	copy(w.data[off:end], p)
+	return len(p), nil
+}
+
+func (w *memWriterAt) ReadAt(p []byte, off int64) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if off >= int64(len(w.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, w.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func fileSHA256(t *testing.T, path string) []byte {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		t.Fatal(err)
+	}
+	return h.Sum(nil)
+}
 
 func TestMain(m *testing.M) {
 	ts = httptest.NewServer(
@@ -75,11 +124,8 @@ func TestChunkedGet(t *testing.T) {
 		NWorkers:  defaultNWorkers,
 		ChunkSize: testChunkSize,
 	}
-	out, err := os.Create(testOut)
-	if err != nil {
-		t.Fatal(err)
-	}
-	err = c.GetFile(ts.URL+testIn, out)
+	out := &memWriterAt{}
+	err := c.GetFile(ts.URL+testIn, out)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -92,11 +138,8 @@ func TestChunkedGetVerifyETag(t *testing.T) {
 		ChunkSize:  testChunkSize,
 		VerifyETag: true,
 	}
-	out, err := os.Create(testOut)
-	if err != nil {
-		t.Fatal(err)
-	}
-	err = c.GetFile(ts.URL+testIn, out)
+	out := &memWriterAt{}
+	err := c.GetFile(ts.URL+testIn, out)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -117,11 +160,8 @@ func TestChunkedGetTLS(t *testing.T) {
 		ChunkSize:  testChunkSize,
 		VerifyETag: true,
 	}
-	out, err := os.Create(testOut)
-	if err != nil {
-		t.Fatal(err)
-	}
-	err = c.GetFile(tlsServer.URL+testIn, out)
+	out := &memWriterAt{}
+	err := c.GetFile(tlsServer.URL+testIn, out)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -143,12 +183,500 @@ func TestChunkError(t *testing.T) {
 		NWorkers:  defaultNWorkers,
 		ChunkSize: testChunkSize,
 	}
-	out, err := os.Create(testOut)
+	out := &memWriterAt{}
+	err := c.GetFile(errServer.URL+testIn, out)
+	if err != nil && !strings.Contains(err.Error(), "chunk at offset 512") {
+		t.Fatalf("Expected error on chunk 512 to be returned")
+	}
+}
+
+func TestChunkedGetChecksum(t *testing.T) {
+	checksumServer := httptest.NewServer(http.HandlerFunc(etagFileServer))
+	defer checksumServer.Close()
+
+	c := ChunkClient{
+		NWorkers:  defaultNWorkers,
+		ChunkSize: testChunkSize,
+		Hash:      sha256.New(),
+		Checksum:  fileSHA256(t, "fixtures"+testIn),
+	}
+	out := &memWriterAt{}
+	if err := c.GetFile(checksumServer.URL+testIn, out); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChunkedGetChecksumMismatch(t *testing.T) {
+	checksumServer := httptest.NewServer(http.HandlerFunc(etagFileServer))
+	defer checksumServer.Close()
+
+	c := ChunkClient{
+		NWorkers:  defaultNWorkers,
+		ChunkSize: testChunkSize,
+		Hash:      sha256.New(),
+		Checksum:  []byte("not the right digest"),
+	}
+	out := &memWriterAt{}
+	err := c.GetFile(checksumServer.URL+testIn, out)
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected ErrChecksumMismatch, got %v", err)
+	}
+}
+
+func TestGetFileWithSidecarChecksum(t *testing.T) {
+	want := fileSHA256(t, "fixtures"+testIn)
+	sidecarServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".sha256") {
+			fmt.Fprintf(w, "%x\n", want)
+			return
+		}
+		etagFileServer(w, r)
+	}))
+	defer sidecarServer.Close()
+
+	c := ChunkClient{
+		NWorkers:  defaultNWorkers,
+		ChunkSize: testChunkSize,
+	}
+	out := &memWriterAt{}
+	if err := c.GetFileWithSidecarChecksum(sidecarServer.URL+testIn, out); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestGetFileWithSidecarChecksumReused verifies that a *ChunkClient reused
+// across two GetFileWithSidecarChecksum calls doesn't leak the first
+// call's Hash/Checksum into the second: GetFileWithSidecarChecksum must
+// not mutate the shared receiver.
+func TestGetFileWithSidecarChecksumReused(t *testing.T) {
+	wantIn := fileSHA256(t, "fixtures"+testIn)
+	wantThrottle := fileSHA256(t, "fixtures/throttle.txt")
+	sidecarServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, testIn+".sha256"):
+			fmt.Fprintf(w, "%x\n", wantIn)
+		case strings.HasSuffix(r.URL.Path, "/throttle.txt.sha256"):
+			fmt.Fprintf(w, "%x\n", wantThrottle)
+		default:
+			etagFileServer(w, r)
+		}
+	}))
+	defer sidecarServer.Close()
+
+	c := ChunkClient{
+		NWorkers:  defaultNWorkers,
+		ChunkSize: testChunkSize,
+	}
+
+	outIn := &memWriterAt{}
+	outThrottle := &memWriterAt{}
+	if err := c.GetFileWithSidecarChecksum(sidecarServer.URL+testIn, outIn); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.GetFileWithSidecarChecksum(sidecarServer.URL+"/throttle.txt", outThrottle); err != nil {
+		t.Fatal(err)
+	}
+	if c.Hash != nil || c.Checksum != nil {
+		t.Fatalf("expected GetFileWithSidecarChecksum to leave the receiver's Hash/Checksum unset, got Hash=%v Checksum=%v", c.Hash, c.Checksum)
+	}
+}
+
+// parseRangeStart extracts the start offset from a "bytes=start-end" Range
+// header.
+func parseRangeStart(t *testing.T, rangeHeader string) int64 {
+	t.Helper()
+	var start, end int64
+	if _, err := fmt.Sscanf(rangeHeader, "bytes=%d-%d", &start, &end); err != nil {
+		t.Fatalf("unexpected Range header %q: %v", rangeHeader, err)
+	}
+	return start
+}
+
+func TestResumeAfterInterrupt(t *testing.T) {
+	dir := t.TempDir()
+	outPath := filepath.Join(dir, "out.txt")
+
+	// Simulate a server that dies partway through the download: it
+	// serves the first two chunks successfully, then fails.
+	interruptedServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rng := r.Header.Get("Range"); rng != "" && parseRangeStart(t, rng) >= 512 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		etagFileServer(w, r)
+	}))
+	defer interruptedServer.Close()
+
+	out, err := os.Create(outPath)
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = c.GetFile(errServer.URL+testIn, out)
-	if err != nil && !strings.Contains(err.Error(), "chunk at offset 512") {
-		t.Fatalf("Expected error on chunk 512 to be returned")
+
+	c := ChunkClient{
+		// A single worker keeps chunk dispatch order deterministic, so
+		// the interrupted server always fails on the same chunk.
+		NWorkers:  1,
+		ChunkSize: testChunkSize,
+		Resumable: true,
+	}
+	err = c.GetFile(interruptedServer.URL+testIn, out)
+	out.Close()
+	if err == nil || !strings.Contains(err.Error(), "chunk at offset 512") {
+		t.Fatalf("expected interrupted download to fail at offset 512, got %v", err)
+	}
+	if _, err := os.Stat(outPath + resumeManifestSuffix); err != nil {
+		t.Fatalf("expected a resume manifest after an interrupted download: %v", err)
+	}
+
+	var mu sync.Mutex
+	var requested []int64
+	resumeServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rng := r.Header.Get("Range"); rng != "" {
+			mu.Lock()
+			requested = append(requested, parseRangeStart(t, rng))
+			mu.Unlock()
+		}
+		etagFileServer(w, r)
+	}))
+	defer resumeServer.Close()
+
+	out, err = os.OpenFile(outPath, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	if err := c.GetFile(resumeServer.URL+testIn, out); err != nil {
+		t.Fatal(err)
+	}
+	for _, offset := range requested {
+		if offset < 512 {
+			t.Fatalf("resume re-requested already-downloaded chunk at offset %d", offset)
+		}
+	}
+	if len(requested) == 0 {
+		t.Fatal("expected resume to request the missing chunks")
+	}
+	if _, err := os.Stat(outPath + resumeManifestSuffix); !os.IsNotExist(err) {
+		t.Fatalf("expected resume manifest to be removed after a complete download, stat err: %v", err)
+	}
+
+	got, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile("fixtures" + testIn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("resumed download contents don't match the source file")
+	}
+}
+
+// TestResumeManifestResetAfterChecksumFailure verifies that a failed
+// post-download checksum check discards the resume manifest rather than
+// leaving every chunk marked done, so the next attempt re-downloads the
+// data instead of wedging on the same mismatch forever.
+func TestResumeManifestResetAfterChecksumFailure(t *testing.T) {
+	dir := t.TempDir()
+	outPath := filepath.Join(dir, "out.txt")
+
+	server := httptest.NewServer(http.HandlerFunc(etagFileServer))
+	defer server.Close()
+
+	out, err := os.Create(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := ChunkClient{
+		NWorkers:  defaultNWorkers,
+		ChunkSize: testChunkSize,
+		Resumable: true,
+		Hash:      sha256.New(),
+		Checksum:  []byte("not the right digest"),
+	}
+	err = c.GetFile(server.URL+testIn, out)
+	out.Close()
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected ErrChecksumMismatch, got %v", err)
+	}
+	if _, err := os.Stat(outPath + resumeManifestSuffix); !os.IsNotExist(err) {
+		t.Fatalf("expected resume manifest to be discarded after a checksum failure, stat err: %v", err)
+	}
+
+	var mu sync.Mutex
+	var requested []int64
+	recordingServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rng := r.Header.Get("Range"); rng != "" {
+			mu.Lock()
+			requested = append(requested, parseRangeStart(t, rng))
+			mu.Unlock()
+		}
+		etagFileServer(w, r)
+	}))
+	defer recordingServer.Close()
+
+	out, err = os.OpenFile(outPath, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	c.Checksum = fileSHA256(t, "fixtures"+testIn)
+	if err := c.GetFile(recordingServer.URL+testIn, out); err != nil {
+		t.Fatal(err)
+	}
+	if len(requested) == 0 {
+		t.Fatal("expected the retried download to re-fetch every chunk instead of treating it as already done")
+	}
+}
+
+// TestResumeProgressCountsAlreadyDoneBytes verifies that Progress on a
+// resumed download reports bytes already on disk from the interrupted
+// run, not just bytes fetched by the current call, so the final callback
+// reaches size.
+func TestResumeProgressCountsAlreadyDoneBytes(t *testing.T) {
+	dir := t.TempDir()
+	outPath := filepath.Join(dir, "out.txt")
+
+	interruptedServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rng := r.Header.Get("Range"); rng != "" && parseRangeStart(t, rng) >= 512 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		etagFileServer(w, r)
+	}))
+	defer interruptedServer.Close()
+
+	out, err := os.Create(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := ChunkClient{
+		NWorkers:  1,
+		ChunkSize: testChunkSize,
+		Resumable: true,
+	}
+	if err := c.GetFile(interruptedServer.URL+testIn, out); err == nil {
+		t.Fatal("expected the interrupted download to fail")
+	}
+	out.Close()
+
+	resumeServer := httptest.NewServer(http.HandlerFunc(etagFileServer))
+	defer resumeServer.Close()
+
+	out, err = os.OpenFile(outPath, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	var lastCompleted, lastTotal int64
+	c.Progress = func(completed, total int64) {
+		atomic.StoreInt64(&lastCompleted, completed)
+		atomic.StoreInt64(&lastTotal, total)
+	}
+	if err := c.GetFile(resumeServer.URL+testIn, out); err != nil {
+		t.Fatal(err)
+	}
+	if lastCompleted != lastTotal {
+		t.Fatalf("expected the last Progress call to report completed == total, got completed=%d total=%d", lastCompleted, lastTotal)
+	}
+}
+
+func TestMultiSourceFailover(t *testing.T) {
+	var failingRequests, healthyRequests int32
+
+	failingServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rng := r.Header.Get("Range"); rng != "" {
+			atomic.AddInt32(&failingRequests, 1)
+			if parseRangeStart(t, rng) == 512 {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+		etagFileServer(w, r)
+	}))
+	defer failingServer.Close()
+
+	healthyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			atomic.AddInt32(&healthyRequests, 1)
+		}
+		etagFileServer(w, r)
+	}))
+	defer healthyServer.Close()
+
+	c := ChunkClient{
+		NWorkers:  1,
+		ChunkSize: testChunkSize,
+		Sources:   []string{failingServer.URL + testIn, healthyServer.URL + testIn},
+	}
+	out := &memWriterAt{}
+	if err := c.GetFile("", out); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&healthyRequests) == 0 {
+		t.Fatal("expected the healthy mirror to serve at least one chunk")
+	}
+	if !bytes.Equal(out.data, mustReadFile(t, "fixtures"+testIn)) {
+		t.Fatal("downloaded contents don't match the source file")
+	}
+}
+
+func TestMultiSourceETagMismatch(t *testing.T) {
+	serverA := httptest.NewServer(http.HandlerFunc(etagFileServer))
+	defer serverA.Close()
+	serverB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", "different-etag")
+		http.FileServer(http.Dir("fixtures")).ServeHTTP(w, r)
+	}))
+	defer serverB.Close()
+
+	c := ChunkClient{
+		NWorkers:   defaultNWorkers,
+		ChunkSize:  testChunkSize,
+		VerifyETag: true,
+		Sources:    []string{serverA.URL + testIn, serverB.URL + testIn},
+	}
+	out := &memWriterAt{}
+	err := c.GetFile("", out)
+	if !errors.Is(err, ErrMirrorMismatch) {
+		t.Fatalf("expected ErrMirrorMismatch, got %v", err)
+	}
+}
+
+func mustReadFile(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestChunkRetrySucceedsAfterTransientErrors(t *testing.T) {
+	var attempts int32
+	flakyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rng := r.Header.Get("Range"); rng != "" && parseRangeStart(t, rng) == 0 {
+			if atomic.AddInt32(&attempts, 1) <= 2 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		}
+		etagFileServer(w, r)
+	}))
+	defer flakyServer.Close()
+
+	c := ChunkClient{
+		NWorkers:       1,
+		ChunkSize:      testChunkSize,
+		MaxRetries:     2,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     5 * time.Millisecond,
+	}
+	out := &memWriterAt{}
+	if err := c.GetFile(flakyServer.URL+testIn, out); err != nil {
+		t.Fatal(err)
+	}
+	if got := atomic.LoadInt32(&attempts); got != 3 {
+		t.Fatalf("expected exactly 3 requests for the flaky chunk, got %d", got)
+	}
+}
+
+func TestChunkRetryFailsFastOnNonRetryableStatus(t *testing.T) {
+	var attempts int32
+	forbiddenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			atomic.AddInt32(&attempts, 1)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		etagFileServer(w, r)
+	}))
+	defer forbiddenServer.Close()
+
+	c := ChunkClient{
+		NWorkers:       1,
+		ChunkSize:      testChunkSize,
+		MaxRetries:     3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     5 * time.Millisecond,
+	}
+	out := &memWriterAt{}
+	if err := c.GetFile(forbiddenServer.URL+testIn, out); err == nil {
+		t.Fatal("expected a 403 to fail the download")
+	}
+	if got := atomic.LoadInt32(&attempts); got != 1 {
+		t.Fatalf("expected a non-retryable status to fail fast with 1 request, got %d", got)
+	}
+}
+
+func TestChunkedGetProgress(t *testing.T) {
+	progressServer := httptest.NewServer(http.HandlerFunc(etagFileServer))
+	defer progressServer.Close()
+
+	var mu sync.Mutex
+	var calls int
+	var lastCompleted, total int64
+	c := ChunkClient{
+		NWorkers:  1,
+		ChunkSize: testChunkSize,
+		Progress: func(completed, totalBytes int64) {
+			mu.Lock()
+			defer mu.Unlock()
+			calls++
+			if completed < lastCompleted {
+				t.Errorf("progress went backwards: %d < %d", completed, lastCompleted)
+			}
+			lastCompleted = completed
+			total = totalBytes
+		},
+	}
+	out := &memWriterAt{}
+	if err := c.GetFile(progressServer.URL+testIn, out); err != nil {
+		t.Fatal(err)
+	}
+	if calls == 0 {
+		t.Fatal("expected Progress to be invoked at least once")
+	}
+	if lastCompleted != total {
+		t.Fatalf("expected final completed bytes %d to equal total %d", lastCompleted, total)
+	}
+}
+
+const testThrottleIn = "/throttle.txt"
+
+func TestChunkedGetThrottled(t *testing.T) {
+	throttleServer := httptest.NewServer(http.HandlerFunc(etagFileServer))
+	defer throttleServer.Close()
+
+	const maxBytesPerSecond = 2048
+	c := ChunkClient{
+		NWorkers:          1,
+		ChunkSize:         1024,
+		MaxBytesPerSecond: maxBytesPerSecond,
+	}
+	out := &memWriterAt{}
+
+	start := time.Now()
+	if err := c.GetFile(throttleServer.URL+testThrottleIn, out); err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+
+	want := mustReadFile(t, "fixtures"+testThrottleIn)
+	if !bytes.Equal(out.data, want) {
+		t.Fatal("throttled download contents don't match the source file")
+	}
+
+	// The token bucket starts with a full second's worth of tokens, so
+	// only bytes beyond the first maxBytesPerSecond should be throttled.
+	minElapsed := time.Duration(len(want)-maxBytesPerSecond) * time.Second / maxBytesPerSecond
+	if elapsed < minElapsed {
+		t.Fatalf("expected throttled download to take at least %s, took %s", minElapsed, elapsed)
 	}
 }